@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// errUnauthorized is returned by authorize when a request fails every
+// configured authentication method.
+var errUnauthorized = errors.New("unauthorized")
+
+// Authorizer is a pluggable way to authenticate and authorize requests to a
+// debug dump handler beyond bearer tokens and mTLS. It returns an opaque
+// principal identifying the caller for audit logging, or an error if the
+// request should be rejected.
+type Authorizer interface {
+	Authorize(r *http.Request) (principal string, err error)
+}
+
+// AuthOptions configures authentication for a debug dump handler registered
+// via WithAuth. A request is authorized if it satisfies any one of the
+// configured methods; if none are configured, every request is rejected,
+// since an explicitly-configured AuthOptions signals the operator wants the
+// endpoint locked down.
+type AuthOptions struct {
+	// BearerTokens is the set of tokens accepted in an
+	// `Authorization: Bearer <token>` header. Tokens are compared in
+	// constant time.
+	BearerTokens []string
+
+	// RequireClientCert authorizes requests that presented a verified TLS
+	// client certificate, i.e. r.TLS.VerifiedChains is non-empty. Like the
+	// other methods it is one alternative: a request without such a
+	// certificate is still authorized by a valid bearer token or by
+	// Authorizer. It's
+	// only meaningful when the handler is served over a *tls.Config with
+	// ClientAuth set to at least tls.VerifyClientCertIfGiven and ClientCAs
+	// configured; crypto/tls only populates VerifiedChains once it has
+	// validated the presented certificate against that pool.
+	RequireClientCert bool
+
+	// Authorizer, if set, is consulted as an additional way to authorize a
+	// request, e.g. to integrate with an existing internal auth system.
+	Authorizer Authorizer
+}
+
+// authorize returns a principal identifying the caller for audit logging,
+// or errUnauthorized if the request satisfies none of opts' configured
+// authentication methods.
+func authorize(r *http.Request, opts AuthOptions) (string, error) {
+	if principal, ok := authorizeBearerToken(r, opts.BearerTokens); ok {
+		return principal, nil
+	}
+
+	if opts.RequireClientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+	}
+
+	if opts.Authorizer != nil {
+		if principal, err := opts.Authorizer.Authorize(r); err == nil {
+			return principal, nil
+		}
+	}
+
+	return "", errUnauthorized
+}
+
+func authorizeBearerToken(r *http.Request, tokens []string) (string, bool) {
+	const prefix = "Bearer "
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return "", false
+	}
+	given := strings.TrimPrefix(header, prefix)
+
+	for _, token := range tokens {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return "token:" + tokenFingerprint(given), true
+		}
+	}
+
+	return "", false
+}
+
+// tokenFingerprint returns a stable opaque identifier for a bearer token,
+// safe to embed in audit logs. It must never leak any part of the raw
+// credential: audit logs are assumed to have broader access than the debug
+// endpoint itself.
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:8]
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuthorizer struct {
+	principal string
+	err       error
+}
+
+func (f *fakeAuthorizer) Authorize(r *http.Request) (string, error) {
+	return f.principal, f.err
+}
+
+func TestAuthorizeBearerToken(t *testing.T) {
+	opts := AuthOptions{BearerTokens: []string{"good-token"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	req.Header.Set("Authorization", "Bearer good-token")
+	principal, err := authorize(req, opts)
+	require.NoError(t, err)
+	require.NotEmpty(t, principal)
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	req.Header.Set("Authorization", "Bearer wrong-token")
+	_, err = authorize(req, opts)
+	require.Equal(t, errUnauthorized, err)
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	_, err = authorize(req, opts)
+	require.Equal(t, errUnauthorized, err)
+}
+
+func TestAuthorizeCustomAuthorizer(t *testing.T) {
+	opts := AuthOptions{Authorizer: &fakeAuthorizer{principal: "svc:foo"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	principal, err := authorize(req, opts)
+	require.NoError(t, err)
+	require.Equal(t, "svc:foo", principal)
+}
+
+func TestAuthorizeNoMethodsConfiguredRejectsEverything(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	_, err := authorize(req, AuthOptions{})
+	require.Equal(t, errUnauthorized, err)
+}
+
+func TestAuthorizeRequireClientCert(t *testing.T) {
+	opts := AuthOptions{RequireClientCert: true}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client.example.com"}}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	principal, err := authorize(req, opts)
+	require.NoError(t, err)
+	require.Equal(t, "cert:client.example.com", principal)
+}
+
+func TestAuthorizeRequireClientCertRejectsUnverifiedCert(t *testing.T) {
+	opts := AuthOptions{RequireClientCert: true}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client.example.com"}}
+
+	// A self-signed or otherwise unvalidated certificate is still surfaced
+	// on PeerCertificates, but crypto/tls only populates VerifiedChains
+	// once it has actually validated the chain against ClientCAs.
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, err := authorize(req, opts)
+	require.Equal(t, errUnauthorized, err)
+}
@@ -0,0 +1,236 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveFormat identifies an archive container/compression format a
+// BundleWriter can emit.
+type ArchiveFormat string
+
+const (
+	// FormatZip produces a .zip archive. It's the original, default
+	// format and is what ZipWriter's WriteZip and WriteTimedZip produce.
+	FormatZip ArchiveFormat = "zip"
+	// FormatTarGz produces a gzip-compressed tar archive.
+	FormatTarGz ArchiveFormat = "tar.gz"
+	// FormatTarZst produces a zstd-compressed tar archive. zstd
+	// generally compresses profile/heap dumps substantially better than
+	// DEFLATE, at the cost of needing a zstd-aware client to read it.
+	FormatTarZst ArchiveFormat = "tar.zst"
+)
+
+// contentTypes maps each ArchiveFormat to the Content-Type and file
+// extension used when serving it over HTTP.
+var contentTypes = map[ArchiveFormat]struct {
+	mediaType string
+	ext       string
+}{
+	FormatZip:    {mediaType: "application/zip", ext: "zip"},
+	FormatTarGz:  {mediaType: "application/gzip", ext: "tar.gz"},
+	FormatTarZst: {mediaType: "application/zstd", ext: "tar.zst"},
+}
+
+// BundleWriter generalizes ZipWriter to support archive formats other than
+// zip. Sources and TimedSources are registered exactly as with ZipWriter;
+// only the archive produced on write differs.
+type BundleWriter interface {
+	// RegisterSource registers a new Source under the given name. It returns
+	// an error if a source is already registered under that name.
+	RegisterSource(name string, source Source) error
+
+	// RegisterTimedSource registers a new TimedSource under the given name.
+	// It returns an error if a timed source is already registered under
+	// that name.
+	RegisterTimedSource(name string, source TimedSource) error
+
+	// RegisterHandler registers an HTTP handler at path on mux. The
+	// archive format is chosen per-request: an explicit `?format=` query
+	// parameter wins, otherwise the Accept header is negotiated,
+	// defaulting to FormatZip. See WithAuth and WithRateLimit.
+	RegisterHandler(path string, mux *http.ServeMux, opts ...RegisterHandlerOption) error
+
+	// WriteBundle writes an archive of the given format, containing all
+	// registered sources, to w.
+	WriteBundle(w io.Writer, format ArchiveFormat) error
+
+	// WriteTimedBundle writes an archive of the given format to w,
+	// additionally collecting every registered TimedSource over duration.
+	WriteTimedBundle(w io.Writer, format ArchiveFormat, duration time.Duration) error
+}
+
+// archiveWriter is the minimal interface each supported ArchiveFormat must
+// implement so the rest of the package (streaming, limits, deterministic
+// mode, manifests) can stay format-agnostic.
+type archiveWriter interface {
+	// CreateEntry returns a writer for a new archive entry named name.
+	// Writes to it are not guaranteed to be visible in the underlying
+	// stream until a subsequent CreateEntry or Close call.
+	CreateEntry(name string, deterministic bool) (io.Writer, error)
+
+	// Close finalizes the archive, flushing any entry still pending.
+	Close() error
+}
+
+// newArchiveWriter returns the archiveWriter for format, writing to w.
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case FormatZip, "":
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case FormatTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{tw: tar.NewWriter(gz), closer: gz}, nil
+	case FormatTarZst:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return &tarArchiveWriter{tw: tar.NewWriter(enc), closer: enc}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// zipArchiveWriter adapts *zip.Writer to archiveWriter.
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) CreateEntry(name string, deterministic bool) (io.Writer, error) {
+	if !deterministic {
+		return a.zw.Create(name)
+	}
+
+	return a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Unix(0, 0).UTC(),
+	})
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// tarArchiveWriter adapts *tar.Writer (optionally wrapping a compressor) to
+// archiveWriter. Unlike zip, tar requires each entry's size up front, so
+// entries are buffered in memory and flushed to the tar stream as soon as
+// the next entry is created (or the archive is closed).
+type tarArchiveWriter struct {
+	tw      *tar.Writer
+	closer  io.Closer
+	pending *tarPendingEntry
+}
+
+type tarPendingEntry struct {
+	name string
+	buf  bytes.Buffer
+	mod  time.Time
+}
+
+func (a *tarArchiveWriter) CreateEntry(name string, deterministic bool) (io.Writer, error) {
+	if err := a.flushPending(); err != nil {
+		return nil, err
+	}
+
+	mod := time.Now()
+	if deterministic {
+		mod = time.Unix(0, 0).UTC()
+	}
+	a.pending = &tarPendingEntry{name: name, mod: mod}
+
+	return &a.pending.buf, nil
+}
+
+func (a *tarArchiveWriter) flushPending() error {
+	if a.pending == nil {
+		return nil
+	}
+
+	header := &tar.Header{
+		Name:    a.pending.name,
+		Size:    int64(a.pending.buf.Len()),
+		Mode:    0o644,
+		ModTime: a.pending.mod,
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := a.tw.Write(a.pending.buf.Bytes()); err != nil {
+		return err
+	}
+
+	a.pending = nil
+	return nil
+}
+
+func (a *tarArchiveWriter) Close() error {
+	if err := a.flushPending(); err != nil {
+		return err
+	}
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.closer != nil {
+		return a.closer.Close()
+	}
+	return nil
+}
+
+// formatFromRequest determines which ArchiveFormat to serve for r. An
+// explicit `?format=` query parameter takes priority; otherwise the
+// Accept header is consulted for one of the archive media types in
+// contentTypes. Accept-Encoding is deliberately not consulted: it's a
+// transport-level content-encoding hint that net/http.Transport (and
+// most HTTP clients) add automatically, not a signal that the caller
+// wants a different archive container. It defaults to FormatZip.
+func formatFromRequest(r *http.Request) (ArchiveFormat, error) {
+	if raw := r.URL.Query().Get("format"); raw != "" {
+		format := ArchiveFormat(raw)
+		if _, ok := contentTypes[format]; !ok {
+			return "", fmt.Errorf("unsupported format %q", raw)
+		}
+		return format, nil
+	}
+
+	accept := strings.ToLower(r.Header.Get("Accept"))
+	if accept != "" {
+		for _, format := range []ArchiveFormat{FormatTarZst, FormatTarGz, FormatZip} {
+			if strings.Contains(accept, contentTypes[format].mediaType) {
+				return format, nil
+			}
+		}
+	}
+
+	return FormatZip, nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/m3db/m3/src/x/instrument"
+	"github.com/stretchr/testify/require"
+)
+
+func readTarGz(t *testing.T, raw []byte) map[string]string {
+	gr, err := gzip.NewReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+	defer gr.Close()
+
+	return readTar(t, gr)
+}
+
+func readTarZst(t *testing.T, raw []byte) map[string]string {
+	zr, err := zstd.NewReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+	defer zr.Close()
+
+	return readTar(t, zr)
+}
+
+func readTar(t *testing.T, r io.Reader) map[string]string {
+	files := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+	return files
+}
+
+func TestWriteBundleTarGz(t *testing.T) {
+	bw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, bw.RegisterSource("a", &fakeSource{content: "aaa"}))
+
+	buff := bytes.NewBuffer([]byte{})
+	require.NoError(t, bw.WriteBundle(buff, FormatTarGz))
+
+	files := readTarGz(t, buff.Bytes())
+	require.Equal(t, "aaa", files["a"])
+}
+
+func TestWriteBundleTarZst(t *testing.T) {
+	bw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, bw.RegisterSource("a", &fakeSource{content: "aaa"}))
+
+	buff := bytes.NewBuffer([]byte{})
+	require.NoError(t, bw.WriteBundle(buff, FormatTarZst))
+
+	files := readTarZst(t, buff.Bytes())
+	require.Equal(t, "aaa", files["a"])
+}
+
+func TestWriteTimedBundleTarGz(t *testing.T) {
+	bw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, bw.RegisterSource("a", &fakeSource{content: "aaa"}))
+	require.NoError(t, bw.RegisterTimedSource("t", &fakeTimedSource{content: "ttt"}))
+
+	buff := bytes.NewBuffer([]byte{})
+	require.NoError(t, bw.WriteTimedBundle(buff, FormatTarGz, 0))
+
+	files := readTarGz(t, buff.Bytes())
+	require.Equal(t, "aaa", files["a"])
+	require.Equal(t, "ttt", files["t"])
+}
+
+func TestFormatFromRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(r *http.Request)
+		want    ArchiveFormat
+		wantErr bool
+	}{
+		{
+			name:  "defaults to zip",
+			setup: func(r *http.Request) {},
+			want:  FormatZip,
+		},
+		{
+			name:  "format query param wins",
+			setup: func(r *http.Request) { r.URL.RawQuery = "format=tar.zst" },
+			want:  FormatTarZst,
+		},
+		{
+			name:    "unsupported format query param",
+			setup:   func(r *http.Request) { r.URL.RawQuery = "format=rar" },
+			wantErr: true,
+		},
+		{
+			name:  "accept zstd",
+			setup: func(r *http.Request) { r.Header.Set("Accept", "application/zstd") },
+			want:  FormatTarZst,
+		},
+		{
+			name:  "accept gzip",
+			setup: func(r *http.Request) { r.Header.Set("Accept", "application/gzip") },
+			want:  FormatTarGz,
+		},
+		{
+			name: "accept-encoding is ignored",
+			// net/http.Transport (and most HTTP clients) set this
+			// automatically; it must not be treated as a format request.
+			setup: func(r *http.Request) { r.Header.Set("Accept-Encoding", "gzip") },
+			want:  FormatZip,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+			tt.setup(r)
+
+			format, err := formatFromRequest(r)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, format)
+		})
+	}
+}
+
+func TestRegisterHandlerFormatNegotiation(t *testing.T) {
+	bw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, bw.RegisterSource("a", &fakeSource{content: "aaa"}))
+
+	mux := http.NewServeMux()
+	require.NoError(t, bw.RegisterHandler("/debug/dump", mux))
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump?format=tar.gz", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.Equal(t, "application/gzip", rr.Header().Get("Content-Type"))
+
+	files := readTarGz(t, rr.Body.Bytes())
+	require.Equal(t, "aaa", files["a"])
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// defaultClusterDumpPath is the path a ClusterSource requests on each peer
+// unless overridden via WithClusterDumpPath.
+const defaultClusterDumpPath = "/debug/dump"
+
+// defaultClusterRequestTimeout bounds how long a single peer is given to
+// respond unless overridden via WithClusterRequestTimeout. A cluster
+// snapshot exists precisely to be useful when some peer is wedged, so
+// fetchPeer must never be allowed to block forever on one.
+const defaultClusterRequestTimeout = 30 * time.Second
+
+// PeerProvider returns the set of peer endpoints a ClusterSource should
+// query. Endpoints are full base URLs (e.g. "http://dbnode01:9004").
+type PeerProvider interface {
+	// Peers returns the current set of peer endpoints.
+	Peers() ([]string, error)
+}
+
+// staticPeerProvider is a PeerProvider over a fixed, unchanging endpoint
+// list, used when ClusterSource is given static config rather than a
+// discovery mechanism.
+type staticPeerProvider []string
+
+func (s staticPeerProvider) Peers() ([]string, error) {
+	return []string(s), nil
+}
+
+// clusterSourceOptions configures a ClusterSource created via
+// NewClusterSource.
+type clusterSourceOptions struct {
+	peers   PeerProvider
+	path    string
+	timeout time.Duration
+}
+
+// ClusterSourceOption configures a ClusterSource created via
+// NewClusterSource.
+type ClusterSourceOption func(*clusterSourceOptions)
+
+// WithPeerProvider overrides the static endpoint list passed to
+// NewClusterSource with a PeerProvider, e.g. one backed by service
+// discovery.
+func WithPeerProvider(peers PeerProvider) ClusterSourceOption {
+	return func(o *clusterSourceOptions) { o.peers = peers }
+}
+
+// WithClusterDumpPath overrides the path requested on each peer. It
+// defaults to "/debug/dump".
+func WithClusterDumpPath(path string) ClusterSourceOption {
+	return func(o *clusterSourceOptions) { o.path = path }
+}
+
+// WithClusterRequestTimeout bounds how long a single peer is given to
+// respond before it's recorded as failed. It defaults to
+// defaultClusterRequestTimeout; a value <= 0 disables the timeout
+// entirely, relying solely on httpClient's own timeout, if any.
+func WithClusterRequestTimeout(d time.Duration) ClusterSourceOption {
+	return func(o *clusterSourceOptions) { o.timeout = d }
+}
+
+// clusterSource fans a dump request out to every peer in a cluster and
+// nests each peer's response under peers/<host>/ in its own output, so it
+// can be registered as a single Source on a ZipWriter to produce a
+// whole-cluster snapshot alongside the local node's own sources.
+type clusterSource struct {
+	httpClient *http.Client
+	opts       clusterSourceOptions
+}
+
+// NewClusterSource returns a Source that, when written, concurrently
+// fetches opts' dump path (by default "/debug/dump") from every peer in
+// endpoints and writes a zip archive with a peers/<host>/status.txt per
+// peer recording the HTTP status, latency, and any error encountered
+// reaching that peer. The body of each peer that answered 200 OK is nested
+// under peers/<host>/dump.zip; a peer that failed or answered with any
+// other status gets no dump.zip. Peer
+// discovery can be overridden with WithPeerProvider, e.g. to source
+// endpoints from a service discovery mechanism instead of the static
+// endpoints list.
+func NewClusterSource(endpoints []string, httpClient *http.Client, opts ...ClusterSourceOption) Source {
+	options := clusterSourceOptions{
+		peers:   staticPeerProvider(endpoints),
+		path:    defaultClusterDumpPath,
+		timeout: defaultClusterRequestTimeout,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &clusterSource{httpClient: httpClient, opts: options}
+}
+
+// peerResult holds the outcome of dumping a single peer.
+type peerResult struct {
+	endpoint string
+	status   string
+	latency  time.Duration
+	body     []byte
+	err      error
+}
+
+func (c *clusterSource) Write(ctx context.Context, w io.Writer) error {
+	peers, err := c.opts.peers.Peers()
+	if err != nil {
+		return fmt.Errorf("listing cluster peers: %w", err)
+	}
+
+	results := make([]peerResult, len(peers))
+	var wg sync.WaitGroup
+	for i, endpoint := range peers {
+		wg.Add(1)
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			results[i] = c.fetchPeer(ctx, endpoint)
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	zw := zip.NewWriter(w)
+	for _, result := range results {
+		host := peerHost(result.endpoint)
+
+		statusEntry, err := zw.Create(fmt.Sprintf("peers/%s/status.txt", host))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(statusEntry, "endpoint: %s\nstatus: %s\nlatency: %s\n", result.endpoint, result.status, result.latency)
+		if result.err != nil {
+			fmt.Fprintf(statusEntry, "error: %s\n", result.err)
+			continue
+		}
+
+		dumpEntry, err := zw.Create(fmt.Sprintf("peers/%s/dump.zip", host))
+		if err != nil {
+			return err
+		}
+		if _, err := dumpEntry.Write(result.body); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// fetchPeer requests c.opts.path from endpoint and captures its outcome;
+// errors reaching a peer are recorded on the result rather than returned,
+// so one unreachable peer doesn't prevent a cluster snapshot of the rest.
+func (c *clusterSource) fetchPeer(ctx context.Context, endpoint string) peerResult {
+	result := peerResult{endpoint: endpoint}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint+c.opts.path, nil)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	if c.opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	result.latency = time.Since(start)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.status = resp.Status
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if resp.StatusCode != http.StatusOK {
+		result.err = fmt.Errorf("peer returned %s", resp.Status)
+		return result
+	}
+
+	result.body = body
+	return result
+}
+
+// peerHost extracts a label for endpoint to use in archive paths, preferring
+// its host:port, and falling back to the raw, unmodified endpoint if it
+// doesn't parse as a URL with a host.
+func peerHost(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return endpoint
+	}
+	return u.Host
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m3db/m3/src/x/instrument"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterSourceWrite(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fake-peer-dump"))
+	}))
+	defer good.Close()
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	src := NewClusterSource([]string{good.URL, bad.URL}, good.Client())
+
+	buff := bytes.NewBuffer([]byte{})
+	require.NoError(t, src.Write(context.Background(), buff))
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	require.NoError(t, err)
+
+	names := make(map[string]*zip.File)
+	for _, f := range zipReader.File {
+		names[f.Name] = f
+	}
+
+	goodHost := strings.TrimPrefix(good.URL, "http://")
+	badHost := strings.TrimPrefix(bad.URL, "http://")
+
+	require.Contains(t, names, "peers/"+goodHost+"/status.txt")
+	require.Contains(t, names, "peers/"+goodHost+"/dump.zip")
+	require.Contains(t, names, "peers/"+badHost+"/status.txt")
+	require.NotContains(t, names, "peers/"+badHost+"/dump.zip")
+
+	rc, err := names["peers/"+goodHost+"/dump.zip"].Open()
+	require.NoError(t, err)
+	defer rc.Close()
+	content := make([]byte, len("fake-peer-dump"))
+	_, err = io.ReadFull(rc, content)
+	require.NoError(t, err)
+	require.Equal(t, "fake-peer-dump", string(content))
+
+	statusRC, err := names["peers/"+badHost+"/status.txt"].Open()
+	require.NoError(t, err)
+	defer statusRC.Close()
+	statusContent, err := io.ReadAll(statusRC)
+	require.NoError(t, err)
+	require.Contains(t, string(statusContent), "error:")
+}
+
+func TestClusterSourceAsRegisteredSource(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fake-peer-dump"))
+	}))
+	defer peer.Close()
+
+	zw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, zw.RegisterSource("cluster", NewClusterSource([]string{peer.URL}, peer.Client())))
+
+	buff := bytes.NewBuffer([]byte{})
+	require.NoError(t, zw.WriteZip(buff))
+
+	zipReader, err := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	require.NoError(t, err)
+
+	var found bool
+	for _, f := range zipReader.File {
+		if f.Name == "cluster" {
+			found = true
+		}
+	}
+	require.True(t, found)
+}
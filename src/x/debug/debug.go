@@ -0,0 +1,680 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package debug implements a way of collecting debug information about a
+// running m3 process (profiles, host metadata, etc) and bundling it into a
+// single archive that can be downloaded over HTTP or written out directly.
+package debug
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3/src/x/instrument"
+	"go.uber.org/zap"
+)
+
+// errSourceExceededByteCap is returned when a source writes more than the
+// configured WithMaxSourceBytes limit.
+var errSourceExceededByteCap = errors.New("source exceeded max byte cap")
+
+// errSourceTimedOut is returned when a source fails to finish writing
+// within the configured WithSourceTimeout.
+var errSourceTimedOut = errors.New("source timed out")
+
+// errorsManifestName is the name of the archive entry written when one or
+// more sources fail in partial-success mode.
+const errorsManifestName = "errors.json"
+
+// Source represents a single, named source of debug data that can be
+// captured instantaneously (e.g. a heap snapshot, host metadata).
+type Source interface {
+	// Write writes this source's data to w. Write must stop and return
+	// promptly once ctx is done, rather than continuing to hold
+	// process-global state (e.g. the CPU profiler) once a caller has given
+	// up on it, such as after WithSourceTimeout elapses.
+	Write(ctx context.Context, w io.Writer) error
+}
+
+// ZipWriter bundles one or more registered Sources into a zip archive,
+// either written directly or served over HTTP.
+type ZipWriter interface {
+	// RegisterSource registers a new Source under the given name. It returns
+	// an error if a source is already registered under that name.
+	RegisterSource(name string, source Source) error
+
+	// RegisterTimedSource registers a new TimedSource under the given name.
+	// It returns an error if a timed source is already registered under
+	// that name.
+	RegisterTimedSource(name string, source TimedSource) error
+
+	// RegisterHandler registers an HTTP handler at path on mux that, when
+	// hit, writes an archive of all registered sources to the response. The
+	// archive format is negotiated as described on BundleWriter. If the
+	// request has a `seconds` query parameter, registered TimedSources are
+	// collected over that duration and bundled into the archive alongside
+	// the instantaneous sources.
+	//
+	// By default the handler is unauthenticated and unlimited; use WithAuth
+	// and WithRateLimit to require authentication and bound concurrent and
+	// hourly dump requests, respectively.
+	RegisterHandler(path string, mux *http.ServeMux, opts ...RegisterHandlerOption) error
+
+	// WriteZip writes the zip archive of all registered sources to w.
+	WriteZip(w io.Writer) error
+
+	// WriteTimedZip writes the zip archive of all registered sources to w,
+	// additionally collecting every registered TimedSource over duration
+	// and running them concurrently.
+	WriteTimedZip(w io.Writer, duration time.Duration) error
+
+	// WriteBundle writes an archive of the given format, containing all
+	// registered sources, to w. See BundleWriter.
+	WriteBundle(w io.Writer, format ArchiveFormat) error
+
+	// WriteTimedBundle writes an archive of the given format to w,
+	// additionally collecting every registered TimedSource over duration.
+	WriteTimedBundle(w io.Writer, format ArchiveFormat, duration time.Duration) error
+}
+
+var _ BundleWriter = (*zipWriter)(nil)
+
+type zipWriter struct {
+	// *sync.Mutex rather than sync.Mutex: zipWriter must remain safe to
+	// copy (tests reflect over it), and embedding a sync.Mutex by value
+	// would copy the lock along with it.
+	*sync.Mutex
+
+	iopts        instrument.Options
+	opts         zipWriterOptions
+	sources      map[string]Source
+	timedSources map[string]TimedSource
+}
+
+// NewZipWriter creates a new ZipWriter with no sources registered.
+func NewZipWriter(iopts instrument.Options, opts ...ZipWriterOption) ZipWriter {
+	options := zipWriterOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &zipWriter{
+		Mutex:        &sync.Mutex{},
+		iopts:        iopts,
+		opts:         options,
+		sources:      make(map[string]Source),
+		timedSources: make(map[string]TimedSource),
+	}
+}
+
+func (zw *zipWriter) RegisterSource(name string, source Source) error {
+	zw.Lock()
+	defer zw.Unlock()
+
+	if _, ok := zw.sources[name]; ok {
+		return fmt.Errorf("source with name %s has already been registered", name)
+	}
+	zw.sources[name] = source
+
+	return nil
+}
+
+func (zw *zipWriter) RegisterTimedSource(name string, source TimedSource) error {
+	zw.Lock()
+	defer zw.Unlock()
+
+	if _, ok := zw.timedSources[name]; ok {
+		return fmt.Errorf("timed source with name %s has already been registered", name)
+	}
+	zw.timedSources[name] = source
+
+	return nil
+}
+
+func (zw *zipWriter) RegisterHandler(path string, mux *http.ServeMux, opts ...RegisterHandlerOption) error {
+	options := registerHandlerOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var limiter *dumpRateLimiter
+	if options.rateLimit != nil {
+		limiter = newDumpRateLimiter(options.rateLimit.maxConcurrent, options.rateLimit.maxPerHour)
+	}
+
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		principal := "anonymous"
+		if options.auth != nil {
+			p, aerr := authorize(r, *options.auth)
+			if aerr != nil {
+				zw.auditLog(principal, r, "", nil, 0, time.Since(start), aerr)
+				http.Error(w, aerr.Error(), http.StatusUnauthorized)
+				return
+			}
+			principal = p
+		}
+
+		if limiter != nil {
+			release, lerr := limiter.acquire()
+			if lerr != nil {
+				zw.auditLog(principal, r, "", nil, 0, time.Since(start), lerr)
+				http.Error(w, lerr.Error(), http.StatusTooManyRequests)
+				return
+			}
+			defer release()
+		}
+
+		format, ferr := formatFromRequest(r)
+		if ferr != nil {
+			http.Error(w, ferr.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var (
+			buff bytes.Buffer
+			err  error
+		)
+
+		sourceNames := zw.sourceNames()
+
+		if seconds := r.URL.Query().Get("seconds"); seconds != "" {
+			n, perr := strconv.Atoi(seconds)
+			if perr != nil || n <= 0 {
+				http.Error(w, "seconds must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			err = zw.WriteTimedBundle(&buff, format, time.Duration(n)*time.Second)
+			sourceNames = append(sourceNames, zw.timedSourceNames()...)
+		} else {
+			err = zw.WriteBundle(&buff, format)
+		}
+
+		zw.auditLog(principal, r, format, sourceNames, buff.Len(), time.Since(start), err)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		ct := contentTypes[format]
+		w.Header().Set("Content-Type", ct.mediaType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="dump.%s"`, ct.ext))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buff.Bytes())
+	})
+
+	return nil
+}
+
+// sourceNames returns the names of all registered instantaneous sources, in
+// sorted order.
+func (zw *zipWriter) sourceNames() []string {
+	zw.Lock()
+	defer zw.Unlock()
+
+	names := make([]string, 0, len(zw.sources))
+	for name := range zw.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// timedSourceNames returns the names of all registered timed sources, in
+// sorted order.
+func (zw *zipWriter) timedSourceNames() []string {
+	zw.Lock()
+	defer zw.Unlock()
+
+	names := make([]string, 0, len(zw.timedSources))
+	for name := range zw.timedSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// auditLog records a structured entry for a single debug dump request, so
+// access to sensitive process state (heap contents, goroutine stacks with
+// argument values, host metadata) can be correlated after the fact.
+func (zw *zipWriter) auditLog(
+	principal string,
+	r *http.Request,
+	format ArchiveFormat,
+	sources []string,
+	bytesWritten int,
+	duration time.Duration,
+	err error,
+) {
+	fields := []zap.Field{
+		zap.String("principal", principal),
+		zap.String("remoteAddr", r.RemoteAddr),
+		zap.String("format", string(format)),
+		zap.Strings("sources", sources),
+		zap.Int("bytesWritten", bytesWritten),
+		zap.Duration("duration", duration),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+		zw.iopts.Logger().Warn("debug dump request failed", fields...)
+		return
+	}
+	zw.iopts.Logger().Info("debug dump request", fields...)
+}
+
+func (zw *zipWriter) WriteZip(w io.Writer) error {
+	return zw.WriteBundle(w, FormatZip)
+}
+
+func (zw *zipWriter) WriteBundle(w io.Writer, format ArchiveFormat) error {
+	zw.Lock()
+	sources := make(map[string]Source, len(zw.sources))
+	for name, source := range zw.sources {
+		sources[name] = source
+	}
+	opts := zw.opts
+	zw.Unlock()
+
+	archive, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	failures := make(map[string]string)
+	var manifestFiles []manifestFile
+
+	if err := writeInstantSources(archive, sources, opts, failures, &manifestFiles); err != nil {
+		return err
+	}
+
+	if err := writeErrorsManifest(archive, failures, opts.deterministic); err != nil {
+		return err
+	}
+	if opts.deterministic {
+		if err := writeManifest(archive, manifestFiles, opts.deterministic); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// writeInstantSources writes every instant source into archive in
+// sourceNames order, recording per-source failures into failures and
+// per-source manifest entries into *manifestFiles (when opts.deterministic
+// is set). It returns a non-nil error only when a source fails and
+// opts.partialSuccess is false, in which case the caller should abort.
+func writeInstantSources(
+	archive archiveWriter,
+	sources map[string]Source,
+	opts zipWriterOptions,
+	failures map[string]string,
+	manifestFiles *[]manifestFile,
+) error {
+	for _, name := range sourceNames(sources, opts.deterministic) {
+		source := sources[name]
+		writeFn := func(ctx context.Context, dst io.Writer) error { return source.Write(ctx, dst) }
+		stats, err := streamSourceToArchive(archive, name, writeFn, opts)
+		if err != nil {
+			if !opts.partialSuccess {
+				return err
+			}
+			failures[name] = err.Error()
+			continue
+		}
+		if opts.deterministic {
+			*manifestFiles = append(*manifestFiles, manifestFile{
+				Name:   name,
+				Size:   stats.size,
+				SHA256: sha256Hex(stats.sha256),
+			})
+		}
+	}
+
+	return nil
+}
+
+func (zw *zipWriter) WriteTimedZip(w io.Writer, duration time.Duration) error {
+	return zw.WriteTimedBundle(w, FormatZip, duration)
+}
+
+func (zw *zipWriter) WriteTimedBundle(w io.Writer, format ArchiveFormat, duration time.Duration) error {
+	zw.Lock()
+	sources := make(map[string]Source, len(zw.sources))
+	for name, source := range zw.sources {
+		sources[name] = source
+	}
+	timedSources := make(map[string]TimedSource, len(zw.timedSources))
+	for name, source := range zw.timedSources {
+		timedSources[name] = source
+	}
+	opts := zw.opts
+	zw.Unlock()
+
+	timedContent, timedFailures, err := collectTimedSources(timedSources, duration, opts)
+	if err != nil {
+		return err
+	}
+
+	archive, err := newArchiveWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	failures := make(map[string]string, len(timedFailures))
+	for name, msg := range timedFailures {
+		failures[name] = msg
+	}
+	var manifestFiles []manifestFile
+
+	if err := writeInstantSources(archive, sources, opts, failures, &manifestFiles); err != nil {
+		return err
+	}
+
+	for _, name := range timedSourceNames(timedContent, opts.deterministic) {
+		buf := timedContent[name]
+		f, err := archive.CreateEntry(name, opts.deterministic)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if opts.deterministic {
+			sum := sha256.Sum256(buf.Bytes())
+			manifestFiles = append(manifestFiles, manifestFile{
+				Name:   name,
+				Size:   int64(buf.Len()),
+				SHA256: sha256Hex(sum[:]),
+			})
+		}
+	}
+
+	if err := writeErrorsManifest(archive, failures, opts.deterministic); err != nil {
+		return err
+	}
+	if opts.deterministic {
+		if err := writeManifest(archive, manifestFiles, opts.deterministic); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// sourceNames returns the names of sources in the order they should be
+// written: sorted if deterministic is set, otherwise in arbitrary map
+// iteration order.
+func sourceNames(sources map[string]Source, deterministic bool) []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	if deterministic {
+		sort.Strings(names)
+	}
+	return names
+}
+
+func timedSourceNames(content map[string]*bytes.Buffer, deterministic bool) []string {
+	names := make([]string, 0, len(content))
+	for name := range content {
+		names = append(names, name)
+	}
+	if deterministic {
+		sort.Strings(names)
+	}
+	return names
+}
+
+// entryStats describes the data written to a single archive entry.
+type entryStats struct {
+	size   int64
+	sha256 []byte
+}
+
+// hashingWriter tees every write through to an underlying writer while
+// tracking the total size and sha256 of everything written.
+type hashingWriter struct {
+	w    io.Writer
+	h    hash.Hash
+	size int64
+}
+
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	n, err := hw.w.Write(p)
+	hw.h.Write(p[:n])
+	hw.size += int64(n)
+	return n, err
+}
+
+// streamSourceToArchive creates an archive entry for name and streams
+// writeFn's output directly into it, without buffering the whole source in
+// memory (zip entries, at least; tar entries are buffered per-entry by the
+// archiveWriter itself, since tar requires each entry's size up front). It
+// enforces opts.maxSourceBytes and opts.sourceTimeout, if set, and returns
+// an error describing why the source failed without aborting the archive
+// itself; the caller decides whether that's fatal.
+func streamSourceToArchive(
+	archive archiveWriter,
+	name string,
+	writeFn func(context.Context, io.Writer) error,
+	opts zipWriterOptions,
+) (entryStats, error) {
+	entry, err := archive.CreateEntry(name, opts.deterministic)
+	if err != nil {
+		return entryStats{}, err
+	}
+	dst := &hashingWriter{w: entry, h: sha256.New()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	go func() {
+		var w io.Writer = pw
+		if opts.maxSourceBytes > 0 {
+			w = &cappedWriter{w: pw, limit: opts.maxSourceBytes}
+		}
+		pw.CloseWithError(writeFn(ctx, w))
+	}()
+
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(dst, pr)
+		copyDone <- err
+	}()
+
+	var copyErr error
+	if opts.sourceTimeout <= 0 {
+		copyErr = <-copyDone
+	} else {
+		select {
+		case copyErr = <-copyDone:
+		case <-time.After(opts.sourceTimeout):
+			// Cancel so a context-aware Source stops holding any
+			// process-global state (e.g. the CPU profiler) for good
+			// instead of leaking until it finishes on its own, mirroring
+			// collectTimedSources.
+			cancel()
+			pr.CloseWithError(errSourceTimedOut)
+			<-copyDone
+			copyErr = errSourceTimedOut
+		}
+	}
+	if copyErr != nil {
+		return entryStats{}, copyErr
+	}
+
+	return entryStats{size: dst.size, sha256: dst.h.Sum(nil)}, nil
+}
+
+// cappedWriter wraps an io.Writer and fails once more than limit bytes have
+// been written to it, so a runaway source can't exhaust memory or disk.
+type cappedWriter struct {
+	w       io.Writer
+	limit   int64
+	written int64
+	err     error
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.written+int64(len(p)) > c.limit {
+		c.err = errSourceExceededByteCap
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	c.err = err
+	return n, err
+}
+
+// capExceeded is implemented by writers that can report whether they've
+// failed partway through writing, such as cappedWriter. It exists so a
+// Source wrapping a library writer that swallows write errors internally
+// (runtime/pprof's CPU profile writer, notably) can still check, after the
+// fact, whether its output was silently truncated.
+type capExceeded interface {
+	// Err returns the first write error encountered, or nil if none has
+	// occurred.
+	Err() error
+}
+
+func (c *cappedWriter) Err() error {
+	return c.err
+}
+
+// checkCapExceeded returns the error recorded by w, if w implements
+// capExceeded and has one, and nil otherwise.
+func checkCapExceeded(w io.Writer) error {
+	if ce, ok := w.(capExceeded); ok {
+		return ce.Err()
+	}
+	return nil
+}
+
+// writeErrorsManifest adds an errors.json entry listing the given source
+// failures, if any. It is a no-op when failures is empty.
+func writeErrorsManifest(archive archiveWriter, failures map[string]string, deterministic bool) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	f, err := archive.CreateEntry(errorsManifestName, deterministic)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(f).Encode(failures)
+}
+
+// collectTimedSources runs every timed source concurrently, each writing
+// into its own capped buffer, and returns the collected buffers keyed by
+// name along with a map of per-source failure messages. Timed sources must
+// be collected before any of their data can be written into the archive,
+// since an archiveWriter can only have a single open entry at a time. If
+// opts.partialSuccess is false, the first source failure is returned as an
+// error instead of being recorded.
+func collectTimedSources(
+	timedSources map[string]TimedSource,
+	duration time.Duration,
+	opts zipWriterOptions,
+) (map[string]*bytes.Buffer, map[string]string, error) {
+	type result struct {
+		name string
+		buf  *bytes.Buffer
+		err  error
+	}
+
+	results := make(chan result, len(timedSources))
+	var wg sync.WaitGroup
+	for name, source := range timedSources {
+		wg.Add(1)
+		go func(name string, source TimedSource) {
+			defer wg.Done()
+
+			var buf bytes.Buffer
+			var w io.Writer = &buf
+			if opts.maxSourceBytes > 0 {
+				w = &cappedWriter{w: &buf, limit: opts.maxSourceBytes}
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- source.Write(ctx, w, duration) }()
+
+			var err error
+			if opts.sourceTimeout > 0 {
+				select {
+				case err = <-done:
+				case <-time.After(opts.sourceTimeout):
+					// Cancel so the source stops holding any
+					// process-global state (e.g. the CPU profiler) for the
+					// remainder of duration, then wait for it to actually
+					// finish unwinding before reusing that state elsewhere.
+					cancel()
+					<-done
+					err = errSourceTimedOut
+				}
+			} else {
+				err = <-done
+			}
+			cancel()
+
+			results <- result{name: name, buf: &buf, err: err}
+		}(name, source)
+	}
+
+	wg.Wait()
+	close(results)
+
+	content := make(map[string]*bytes.Buffer, len(timedSources))
+	failures := make(map[string]string)
+	for res := range results {
+		if res.err != nil {
+			if !opts.partialSuccess {
+				return nil, nil, fmt.Errorf("timed source %s failed: %v", res.name, res.err)
+			}
+			failures[res.name] = res.err.Error()
+			continue
+		}
+		content[res.name] = res.buf
+	}
+
+	return content, failures, nil
+}
@@ -23,6 +23,7 @@ package debug
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -41,7 +42,7 @@ type fakeSource struct {
 	content   string
 }
 
-func (f *fakeSource) Write(w io.Writer) error {
+func (f *fakeSource) Write(_ context.Context, w io.Writer) error {
 	f.called = true
 	if f.shouldErr {
 		return errors.New("bad write")
@@ -189,6 +190,152 @@ func TestHTTPEndpoint(t *testing.T) {
 	})
 }
 
+// checkedSource, unlike fakeSource, propagates the error from its
+// underlying Write call, so it can be used to exercise WithMaxSourceBytes.
+type checkedSource struct {
+	content string
+}
+
+func (c *checkedSource) Write(_ context.Context, w io.Writer) error {
+	_, err := w.Write([]byte(c.content))
+	return err
+}
+
+func TestWriteZipPartialSuccess(t *testing.T) {
+	zipWriter := NewZipWriter(instrument.NewOptions(), WithPartialSuccess(true))
+	good := &fakeSource{content: "fine"}
+	bad := &fakeSource{shouldErr: true}
+	require.NoError(t, zipWriter.RegisterSource("good", good))
+	require.NoError(t, zipWriter.RegisterSource("bad", bad))
+
+	buff := bytes.NewBuffer([]byte{})
+	err := zipWriter.WriteZip(buff)
+	require.NoError(t, err)
+
+	zipReader, zerr := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	require.NoError(t, zerr)
+
+	names := make(map[string]bool)
+	for _, f := range zipReader.File {
+		names[f.Name] = true
+	}
+	require.True(t, names["good"])
+	require.True(t, names["errors.json"])
+}
+
+func TestHTTPEndpointPartialSuccess(t *testing.T) {
+	mux := http.NewServeMux()
+	path := "/debug/dump"
+
+	zw := NewZipWriter(instrument.NewOptions(), WithPartialSuccess(true))
+	good := &fakeSource{content: "fine"}
+	bad := &fakeSource{shouldErr: true}
+	require.NoError(t, zw.RegisterSource("good", good))
+	require.NoError(t, zw.RegisterSource("bad", bad))
+	require.NoError(t, zw.RegisterHandler(path, mux))
+
+	req, err := http.NewRequest("GET", path, nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestWriteZipMaxSourceBytes(t *testing.T) {
+	zipWriter := NewZipWriter(
+		instrument.NewOptions(),
+		WithMaxSourceBytes(4),
+		WithPartialSuccess(true),
+	)
+	tooBig := &checkedSource{content: "way too much content"}
+	require.NoError(t, zipWriter.RegisterSource("tooBig", tooBig))
+
+	buff := bytes.NewBuffer([]byte{})
+	err := zipWriter.WriteZip(buff)
+	require.NoError(t, err)
+
+	zipReader, zerr := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	require.NoError(t, zerr)
+
+	names := make(map[string]bool)
+	for _, f := range zipReader.File {
+		names[f.Name] = true
+	}
+	require.True(t, names["errors.json"])
+}
+
+type fakeTimedSource struct {
+	called   bool
+	duration time.Duration
+	content  string
+}
+
+func (f *fakeTimedSource) Write(ctx context.Context, w io.Writer, d time.Duration) error {
+	f.called = true
+	f.duration = d
+	w.Write([]byte(f.content))
+	return nil
+}
+
+func TestRegisterTimedSourceSameName(t *testing.T) {
+	zipWriter := NewZipWriter(instrument.NewOptions())
+	fs := &fakeTimedSource{}
+	err := zipWriter.RegisterTimedSource("test", fs)
+	require.NoError(t, err)
+	err = zipWriter.RegisterTimedSource("test", fs)
+	require.Error(t, err)
+}
+
+func TestWriteTimedZip(t *testing.T) {
+	zipWriter := NewZipWriter(instrument.NewOptions())
+	fs := &fakeSource{content: "snapshot"}
+	fts := &fakeTimedSource{content: "timed"}
+	require.NoError(t, zipWriter.RegisterSource("snapshot", fs))
+	require.NoError(t, zipWriter.RegisterTimedSource("timed", fts))
+
+	buff := bytes.NewBuffer([]byte{})
+	err := zipWriter.WriteTimedZip(buff, 5*time.Millisecond)
+	require.NoError(t, err)
+
+	zipReader, zerr := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	require.NoError(t, zerr)
+
+	names := make(map[string]bool)
+	for _, f := range zipReader.File {
+		names[f.Name] = true
+	}
+	require.True(t, names["snapshot"])
+	require.True(t, names["timed"])
+	require.True(t, fts.called)
+	require.Equal(t, 5*time.Millisecond, fts.duration)
+}
+
+func TestHTTPEndpointSecondsParam(t *testing.T) {
+	mux := http.NewServeMux()
+	path := "/debug/dump"
+
+	zw := NewZipWriter(instrument.NewOptions())
+	fts := &fakeTimedSource{content: "timed"}
+	require.NoError(t, zw.RegisterTimedSource("timed", fts))
+	require.NoError(t, zw.RegisterHandler(path, mux))
+
+	req, err := http.NewRequest("GET", path+"?seconds=1", nil)
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.True(t, fts.called)
+	require.Equal(t, time.Second, fts.duration)
+
+	req, err = http.NewRequest("GET", path+"?seconds=notanumber", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
 func TestDefaultSources(t *testing.T) {
 	defaultSources := []string{
 		"cpuSource",
@@ -242,3 +389,40 @@ func TestDefaultSources(t *testing.T) {
 	}
 
 }
+
+func TestDefaultSourcesOptions(t *testing.T) {
+	timedSources := map[string]DefaultSourceOption{
+		ContinuousCPUProfileName: WithContinuousCPUProfile(),
+		ExecutionTraceName:       WithExecutionTrace(),
+		BlockProfileName:         WithBlockProfile(),
+		MutexProfileName:         WithMutexProfile(),
+		ThreadCreateProfileName:  WithThreadCreateProfile(),
+		AllocsProfileName:        WithAllocsProfile(),
+	}
+
+	var opts []DefaultSourceOption
+	for _, opt := range timedSources {
+		opts = append(opts, opt)
+	}
+
+	zw, err := NewZipWriterWithDefaultSources(time.Second, instrument.NewOptions(), opts...)
+	require.NoError(t, err)
+
+	iv := reflect.ValueOf(zw).Elem().Interface()
+	z, ok := iv.(zipWriter)
+	require.True(t, ok)
+
+	for name := range timedSources {
+		_, ok := z.timedSources[name]
+		require.True(t, ok, "expected timed source %s to be registered", name)
+	}
+}
+
+func TestCPUProfileSourceCapExceeded(t *testing.T) {
+	// runtime/pprof's CPU profile writer swallows write errors internally,
+	// so without checkCapExceeded this would report success despite being
+	// truncated.
+	capped := &cappedWriter{w: &bytes.Buffer{}, limit: 1}
+	err := newCPUProfileSource(50*time.Millisecond).Write(context.Background(), capped)
+	require.ErrorIs(t, err, errSourceExceededByteCap)
+}
@@ -0,0 +1,240 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/m3db/m3/src/x/instrument"
+)
+
+const (
+	cpuProfileName       = "cpuSource"
+	heapProfileName      = "heapSource"
+	hostName             = "hostSource"
+	goroutineProfileName = "goroutineProfile"
+)
+
+// defaultSourceOptions configures which optional, longer-running timed
+// sources NewZipWriterWithDefaultSources registers in addition to the
+// always-on instantaneous sources.
+type defaultSourceOptions struct {
+	includeExecutionTrace bool
+	includeBlockProfile   bool
+	includeMutexProfile   bool
+	includeThreadCreate   bool
+	includeAllocsProfile  bool
+	includeContinuousCPU  bool
+}
+
+// DefaultSourceOption configures NewZipWriterWithDefaultSources.
+type DefaultSourceOption func(*defaultSourceOptions)
+
+// WithExecutionTrace enables registering a continuous execution trace
+// TimedSource, collected via the `?seconds=N` handler parameter.
+func WithExecutionTrace() DefaultSourceOption {
+	return func(o *defaultSourceOptions) { o.includeExecutionTrace = true }
+}
+
+// WithBlockProfile enables registering a continuous block profile
+// TimedSource, collected via the `?seconds=N` handler parameter.
+func WithBlockProfile() DefaultSourceOption {
+	return func(o *defaultSourceOptions) { o.includeBlockProfile = true }
+}
+
+// WithMutexProfile enables registering a continuous mutex profile
+// TimedSource, collected via the `?seconds=N` handler parameter.
+func WithMutexProfile() DefaultSourceOption {
+	return func(o *defaultSourceOptions) { o.includeMutexProfile = true }
+}
+
+// WithThreadCreateProfile enables registering a threadcreate profile
+// TimedSource, collected via the `?seconds=N` handler parameter.
+func WithThreadCreateProfile() DefaultSourceOption {
+	return func(o *defaultSourceOptions) { o.includeThreadCreate = true }
+}
+
+// WithAllocsProfile enables registering an allocs profile TimedSource,
+// collected via the `?seconds=N` handler parameter.
+func WithAllocsProfile() DefaultSourceOption {
+	return func(o *defaultSourceOptions) { o.includeAllocsProfile = true }
+}
+
+// WithContinuousCPUProfile enables registering a continuous CPU profile
+// TimedSource, collected via the `?seconds=N` handler parameter, in
+// addition to the always-on fixed-interval CPU profile.
+func WithContinuousCPUProfile() DefaultSourceOption {
+	return func(o *defaultSourceOptions) { o.includeContinuousCPU = true }
+}
+
+// NewZipWriterWithDefaultSources returns a new ZipWriter with the standard
+// set of instantaneous and short-lived profile sources already registered:
+// a CPU profile collected over interval, a heap profile, a goroutine
+// profile, and host metadata. Additional, longer-running TimedSources
+// (execution traces, block/mutex/threadcreate/allocs profiles) can be
+// enabled via opts and are collected when a request to the registered
+// handler includes a `?seconds=N` parameter.
+func NewZipWriterWithDefaultSources(
+	interval time.Duration,
+	iopts instrument.Options,
+	opts ...DefaultSourceOption,
+) (ZipWriter, error) {
+	options := defaultSourceOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	zw := NewZipWriter(iopts)
+
+	if err := zw.RegisterSource(cpuProfileName, newCPUProfileSource(interval)); err != nil {
+		return nil, err
+	}
+	if err := zw.RegisterSource(heapProfileName, newHeapProfileSource()); err != nil {
+		return nil, err
+	}
+	if err := zw.RegisterSource(hostName, newHostSource()); err != nil {
+		return nil, err
+	}
+	if err := zw.RegisterSource(goroutineProfileName, newGoroutineProfileSource()); err != nil {
+		return nil, err
+	}
+
+	if options.includeContinuousCPU {
+		if err := zw.RegisterTimedSource(ContinuousCPUProfileName, NewContinuousCPUProfileSource()); err != nil {
+			return nil, err
+		}
+	}
+	if options.includeExecutionTrace {
+		if err := zw.RegisterTimedSource(ExecutionTraceName, NewExecutionTraceSource()); err != nil {
+			return nil, err
+		}
+	}
+	if options.includeBlockProfile {
+		if err := zw.RegisterTimedSource(BlockProfileName, NewBlockProfileSource()); err != nil {
+			return nil, err
+		}
+	}
+	if options.includeMutexProfile {
+		if err := zw.RegisterTimedSource(MutexProfileName, NewMutexProfileSource()); err != nil {
+			return nil, err
+		}
+	}
+	if options.includeThreadCreate {
+		if err := zw.RegisterTimedSource(ThreadCreateProfileName, NewThreadCreateProfileSource()); err != nil {
+			return nil, err
+		}
+	}
+	if options.includeAllocsProfile {
+		if err := zw.RegisterTimedSource(AllocsProfileName, NewAllocsProfileSource()); err != nil {
+			return nil, err
+		}
+	}
+
+	return zw, nil
+}
+
+// cpuProfileSource collects a CPU profile over a fixed, hardcoded duration.
+type cpuProfileSource struct {
+	duration time.Duration
+}
+
+func newCPUProfileSource(duration time.Duration) Source {
+	return &cpuProfileSource{duration: duration}
+}
+
+func (s *cpuProfileSource) Write(ctx context.Context, w io.Writer) error {
+	if err := pprof.StartCPUProfile(w); err != nil {
+		return err
+	}
+	waitFor(ctx, s.duration)
+	pprof.StopCPUProfile()
+
+	// runtime/pprof's CPU profile writer swallows w's write errors
+	// internally and StopCPUProfile doesn't return one, so without this a
+	// capped profile would silently report success despite being
+	// truncated.
+	return checkCapExceeded(w)
+}
+
+// heapProfileSource captures a snapshot of the current heap.
+type heapProfileSource struct{}
+
+func newHeapProfileSource() Source {
+	return &heapProfileSource{}
+}
+
+func (s *heapProfileSource) Write(_ context.Context, w io.Writer) error {
+	runtime.GC()
+	return pprof.Lookup("heap").WriteTo(w, 0)
+}
+
+// goroutineProfileSource captures a snapshot of all running goroutines.
+type goroutineProfileSource struct{}
+
+func newGoroutineProfileSource() Source {
+	return &goroutineProfileSource{}
+}
+
+func (s *goroutineProfileSource) Write(_ context.Context, w io.Writer) error {
+	return pprof.Lookup("goroutine").WriteTo(w, 2)
+}
+
+// hostInfo captures basic metadata about the host and runtime a dump was
+// taken on, useful for correlating dumps across a cluster.
+type hostInfo struct {
+	Hostname     string `json:"hostname"`
+	NumCPU       int    `json:"numCPU"`
+	NumGoroutine int    `json:"numGoroutine"`
+	GOOS         string `json:"goos"`
+	GOARCH       string `json:"goarch"`
+	GoVersion    string `json:"goVersion"`
+}
+
+// hostSource captures basic host and runtime metadata.
+type hostSource struct{}
+
+func newHostSource() Source {
+	return &hostSource{}
+}
+
+func (s *hostSource) Write(_ context.Context, w io.Writer) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	info := hostInfo{
+		Hostname:     hostname,
+		NumCPU:       runtime.NumCPU(),
+		NumGoroutine: runtime.NumGoroutine(),
+		GOOS:         runtime.GOOS,
+		GOARCH:       runtime.GOARCH,
+		GoVersion:    runtime.Version(),
+	}
+
+	return json.NewEncoder(w).Encode(info)
+}
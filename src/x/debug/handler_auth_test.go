@@ -0,0 +1,69 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/m3db/m3/src/x/instrument"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterHandlerWithAuthRejectsUnauthenticated(t *testing.T) {
+	zw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, zw.RegisterSource("a", &fakeSource{content: "aaa"}))
+
+	mux := http.NewServeMux()
+	require.NoError(t, zw.RegisterHandler("/debug/dump", mux, WithAuth(AuthOptions{
+		BearerTokens: []string{"good-token"},
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	req.Header.Set("Authorization", "Bearer good-token")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRegisterHandlerWithRateLimit(t *testing.T) {
+	zw := NewZipWriter(instrument.NewOptions())
+	require.NoError(t, zw.RegisterSource("a", &fakeSource{content: "aaa"}))
+
+	mux := http.NewServeMux()
+	require.NoError(t, zw.RegisterHandler("/debug/dump", mux, WithRateLimit(10, 1)))
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/dump", nil)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusTooManyRequests, rr.Code)
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	rtdebug "runtime/debug"
+	"sort"
+)
+
+// manifestName is the name of the archive entry written in deterministic
+// mode describing every other file in the archive.
+const manifestName = "manifest.json"
+
+// manifestFile describes a single archive entry for reproducibility/diffing.
+type manifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifest is the contents of manifest.json, emitted in deterministic mode.
+type manifest struct {
+	Files    []manifestFile `json:"files"`
+	Build    string         `json:"build"`
+	Hostname string         `json:"hostname"`
+}
+
+func writeManifest(archive archiveWriter, files []manifestFile, deterministic bool) error {
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	m := manifest{
+		Files:    files,
+		Build:    buildVersion(),
+		Hostname: hostname,
+	}
+
+	f, err := archive.CreateEntry(manifestName, deterministic)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(f).Encode(m)
+}
+
+// buildVersion returns a best-effort identifier for the running m3 binary,
+// derived from the embedded Go module build info.
+func buildVersion() string {
+	info, ok := rtdebug.ReadBuildInfo()
+	if !ok {
+		return runtime.Version()
+	}
+
+	return fmt.Sprintf("%s@%s (%s)", info.Main.Path, info.Main.Version, runtime.Version())
+}
+
+// HashZip computes a stable, order-independent hash over the contents of a
+// zip archive, modeled on golang.org/x/mod/sumdb/dirhash.Hash1: each file's
+// sha256 is computed, the "<hex sha256>  <name>\n" lines are sorted by
+// name and concatenated, and the sha256 of that is base64-encoded with an
+// "h1:" prefix. Two dumps with identical file contents hash identically
+// regardless of the order their entries were written in.
+func HashZip(ra io.ReaderAt, size int64) (string, error) {
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(zr.File))
+	byName := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		byName[f.Name] = f
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		rc, err := byName[name].Open()
+		if err != nil {
+			return "", err
+		}
+
+		hf := sha256.New()
+		_, err = io.Copy(hf, rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "%x  %s\n", hf.Sum(nil), name)
+	}
+
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func sha256Hex(sum []byte) string {
+	return hex.EncodeToString(sum)
+}
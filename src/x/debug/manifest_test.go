@@ -0,0 +1,118 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/x/instrument"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeterministicZipWriter(t *testing.T, contents map[string]string) ZipWriter {
+	zw := NewZipWriter(instrument.NewOptions(), WithDeterministic(true))
+	for name, content := range contents {
+		require.NoError(t, zw.RegisterSource(name, &fakeSource{content: content}))
+	}
+	return zw
+}
+
+func TestWriteZipDeterministicManifest(t *testing.T) {
+	contents := map[string]string{
+		"b": "bbb",
+		"a": "aa",
+	}
+	zw := newDeterministicZipWriter(t, contents)
+
+	buff := bytes.NewBuffer([]byte{})
+	require.NoError(t, zw.WriteZip(buff))
+
+	zipReader, zerr := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	require.NoError(t, zerr)
+
+	var names []string
+	var manifestEntry *zip.File
+	for _, f := range zipReader.File {
+		names = append(names, f.Name)
+		if f.Name == manifestName {
+			manifestEntry = f
+		}
+		require.Equal(t, time.Unix(0, 0).UTC(), f.Modified.UTC())
+	}
+	require.Equal(t, []string{"a", "b", manifestName}, names)
+
+	require.NotNil(t, manifestEntry)
+	rc, err := manifestEntry.Open()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	var m manifest
+	require.NoError(t, json.NewDecoder(rc).Decode(&m))
+	require.Len(t, m.Files, 2)
+	require.NotEmpty(t, m.Build)
+	require.NotEmpty(t, m.Hostname)
+	for _, f := range m.Files {
+		require.NotEmpty(t, f.SHA256)
+		require.NotZero(t, f.Size)
+	}
+}
+
+func TestWriteZipDeterministicReproducible(t *testing.T) {
+	contents := map[string]string{"a": "aa", "b": "bbb"}
+
+	zw1 := newDeterministicZipWriter(t, contents)
+	buff1 := bytes.NewBuffer([]byte{})
+	require.NoError(t, zw1.WriteZip(buff1))
+
+	zw2 := newDeterministicZipWriter(t, contents)
+	buff2 := bytes.NewBuffer([]byte{})
+	require.NoError(t, zw2.WriteZip(buff2))
+
+	require.Equal(t, buff1.Bytes(), buff2.Bytes())
+}
+
+func TestHashZip(t *testing.T) {
+	contents := map[string]string{"a": "aa", "b": "bbb"}
+
+	zw1 := newDeterministicZipWriter(t, contents)
+	buff1 := bytes.NewBuffer([]byte{})
+	require.NoError(t, zw1.WriteZip(buff1))
+
+	hash1, err := HashZip(bytes.NewReader(buff1.Bytes()), int64(buff1.Len()))
+	require.NoError(t, err)
+	require.Contains(t, hash1, "h1:")
+
+	// A zip with the same two sources, registered in the opposite order,
+	// should still hash identically.
+	zw2 := NewZipWriter(instrument.NewOptions(), WithDeterministic(true))
+	require.NoError(t, zw2.RegisterSource("b", &fakeSource{content: "bbb"}))
+	require.NoError(t, zw2.RegisterSource("a", &fakeSource{content: "aa"}))
+	buff2 := bytes.NewBuffer([]byte{})
+	require.NoError(t, zw2.WriteZip(buff2))
+
+	hash2, err := HashZip(bytes.NewReader(buff2.Bytes()), int64(buff2.Len()))
+	require.NoError(t, err)
+	require.Equal(t, hash1, hash2)
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import "time"
+
+// zipWriterOptions holds the configurable behavior of a ZipWriter, set via
+// ZipWriterOption functions passed to NewZipWriter.
+type zipWriterOptions struct {
+	maxSourceBytes int64
+	sourceTimeout  time.Duration
+	partialSuccess bool
+	deterministic  bool
+}
+
+// ZipWriterOption configures a ZipWriter created via NewZipWriter.
+type ZipWriterOption func(*zipWriterOptions)
+
+// WithMaxSourceBytes caps the number of bytes read from any single source
+// (instantaneous or timed). A source that exceeds the cap is treated as
+// failed. A timed source's partial output is discarded; an instantaneous
+// source is streamed into an entry created before it runs, so that entry
+// stays in the archive holding whatever was written before the cap was
+// hit. A value <= 0 means no cap, which is the default.
+func WithMaxSourceBytes(n int64) ZipWriterOption {
+	return func(o *zipWriterOptions) { o.maxSourceBytes = n }
+}
+
+// WithSourceTimeout bounds how long a single source (instantaneous or
+// timed) is given to finish writing before it's treated as failed. A value
+// <= 0 means no timeout, which is the default.
+func WithSourceTimeout(d time.Duration) ZipWriterOption {
+	return func(o *zipWriterOptions) { o.sourceTimeout = d }
+}
+
+// WithPartialSuccess controls what happens when a source fails, exceeds its
+// byte cap, or times out. When true, the ZipWriter still finalizes the
+// archive with the remaining, successful sources and adds an `errors.json`
+// entry describing what failed and why. When false (the default), the
+// first source failure aborts the whole write and is returned as an error.
+func WithPartialSuccess(b bool) ZipWriterOption {
+	return func(o *zipWriterOptions) { o.partialSuccess = b }
+}
+
+// WithDeterministic makes the ZipWriter produce byte-for-byte reproducible
+// archives across runs of the same binary and state: instantaneous sources
+// are written in sorted name order, then timed sources in sorted name
+// order, then `errors.json` (if any source failed), each with a fixed
+// (Unix epoch) modtime. In a zip archive that modtime is carried in an
+// extended-timestamp extra field, the only extra field written. A
+// `manifest.json` entry is added last, listing each source file's name,
+// size, and sha256, alongside build/version/hostname metadata. This lets
+// support engineers diff two dumps, or use HashZip to tell whether they
+// came from the same binary and state without diffing byte-by-byte.
+func WithDeterministic(b bool) ZipWriterOption {
+	return func(o *zipWriterOptions) { o.deterministic = b }
+}
+
+// registerHandlerOptions holds the configurable behavior of a single
+// RegisterHandler call, set via RegisterHandlerOption functions.
+type registerHandlerOptions struct {
+	auth      *AuthOptions
+	rateLimit *rateLimitOptions
+}
+
+// rateLimitOptions holds the parameters passed to WithRateLimit.
+type rateLimitOptions struct {
+	maxConcurrent int
+	maxPerHour    int
+}
+
+// RegisterHandlerOption configures a handler registered via RegisterHandler.
+type RegisterHandlerOption func(*registerHandlerOptions)
+
+// WithAuth requires every request to the handler to satisfy one of opts'
+// configured authentication methods, rejecting any that don't with
+// a 401. Without WithAuth, the handler is unauthenticated.
+func WithAuth(opts AuthOptions) RegisterHandlerOption {
+	return func(o *registerHandlerOptions) { o.auth = &opts }
+}
+
+// WithRateLimit bounds the handler to at most maxConcurrent dumps in
+// flight at once and to a token bucket holding up to maxPerHour tokens and
+// refilling at maxPerHour per hour, rejecting requests beyond either limit
+// with a 429. Since the bucket starts full and refills continuously, a
+// burst can take more than maxPerHour dumps within a single rolling hour. A value <= 0 for
+// either parameter falls back to its default: 1 concurrent dump and 12 per
+// hour. Without WithRateLimit, the handler is unlimited.
+func WithRateLimit(maxConcurrent, maxPerHour int) RegisterHandlerOption {
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMaxConcurrentDumps
+	}
+	if maxPerHour <= 0 {
+		maxPerHour = defaultMaxDumpsPerHour
+	}
+	return func(o *registerHandlerOptions) {
+		o.rateLimit = &rateLimitOptions{maxConcurrent: maxConcurrent, maxPerHour: maxPerHour}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errTooManyConcurrentDumps is returned when a dump request arrives while
+// maxConcurrent other dumps are already in flight.
+var errTooManyConcurrentDumps = errors.New("too many concurrent debug dumps")
+
+// errRateLimitExceeded is returned when a dump request would exceed the
+// configured number of dumps per hour.
+var errRateLimitExceeded = errors.New("debug dump rate limit exceeded")
+
+// defaultMaxConcurrentDumps and defaultMaxDumpsPerHour are the defaults
+// WithRateLimit applies when not overridden, chosen because a debug dump
+// can be expensive (CPU profiles block, heap dumps are large) and is a
+// support/incident tool rather than something callers should poll.
+const (
+	defaultMaxConcurrentDumps = 1
+	defaultMaxDumpsPerHour    = 12
+)
+
+// dumpRateLimiter bounds both how many debug dumps can run at once and how
+// many can run in a rolling hour, via a semaphore and a token bucket that
+// refills continuously at maxPerHour/time.Hour.
+type dumpRateLimiter struct {
+	sem chan struct{}
+
+	mu         sync.Mutex
+	maxTokens  float64
+	refillRate float64 // tokens per nanosecond
+	tokens     float64
+	lastRefill time.Time
+}
+
+// newDumpRateLimiter returns a dumpRateLimiter allowing up to maxConcurrent
+// dumps in flight at once, with a token bucket that starts full at
+// maxPerHour tokens and refills at maxPerHour per hour.
+func newDumpRateLimiter(maxConcurrent, maxPerHour int) *dumpRateLimiter {
+	return &dumpRateLimiter{
+		sem:        make(chan struct{}, maxConcurrent),
+		maxTokens:  float64(maxPerHour),
+		refillRate: float64(maxPerHour) / float64(time.Hour),
+		tokens:     float64(maxPerHour),
+		lastRefill: time.Now(),
+	}
+}
+
+// acquire reserves a concurrency slot and an hourly-rate token. On success
+// it returns a release func the caller must call when the dump completes;
+// on failure it returns the reason the request was rejected.
+func (l *dumpRateLimiter) acquire() (release func(), err error) {
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		return nil, errTooManyConcurrentDumps
+	}
+
+	if !l.takeToken() {
+		<-l.sem
+		return nil, errRateLimitExceeded
+	}
+
+	return func() { <-l.sem }, nil
+}
+
+func (l *dumpRateLimiter) takeToken() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(l.lastRefill)
+	l.lastRefill = now
+
+	l.tokens += float64(elapsed) * l.refillRate
+	if l.tokens > l.maxTokens {
+		l.tokens = l.maxTokens
+	}
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+const (
+	// BlockProfileName is the name under which the block profile timed
+	// source is registered by default.
+	BlockProfileName = "blockProfile"
+	// MutexProfileName is the name under which the mutex profile timed
+	// source is registered by default.
+	MutexProfileName = "mutexProfile"
+	// ThreadCreateProfileName is the name under which the threadcreate
+	// profile timed source is registered by default.
+	ThreadCreateProfileName = "threadcreateProfile"
+	// AllocsProfileName is the name under which the allocs profile timed
+	// source is registered by default.
+	AllocsProfileName = "allocsProfile"
+	// ContinuousCPUProfileName is the name under which the continuous CPU
+	// profile timed source is registered by default.
+	ContinuousCPUProfileName = "continuousCPUProfile"
+	// ExecutionTraceName is the name under which the execution trace timed
+	// source is registered by default.
+	ExecutionTraceName = "executionTrace"
+)
+
+// TimedSource represents a source of debug data that is collected over a
+// caller-specified duration, rather than instantaneously, such as a CPU
+// profile or an execution trace.
+type TimedSource interface {
+	// Write collects data for duration d and writes it to w. Write must
+	// stop collecting and return promptly once ctx is done, rather than
+	// running the full duration d, so that a caller giving up early (e.g.
+	// after WithSourceTimeout elapses) does not leave process-global state
+	// such as the CPU profiler held for the remainder of d.
+	Write(ctx context.Context, w io.Writer, d time.Duration) error
+}
+
+// NewContinuousCPUProfileSource returns a TimedSource that collects a CPU
+// profile for the requested duration.
+func NewContinuousCPUProfileSource() TimedSource {
+	return &continuousCPUProfileSource{}
+}
+
+type continuousCPUProfileSource struct{}
+
+func (s *continuousCPUProfileSource) Write(ctx context.Context, w io.Writer, d time.Duration) error {
+	if err := pprof.StartCPUProfile(w); err != nil {
+		return err
+	}
+	waitFor(ctx, d)
+	pprof.StopCPUProfile()
+
+	// runtime/pprof's CPU profile writer swallows w's write errors
+	// internally and StopCPUProfile doesn't return one, so without this a
+	// capped profile would silently report success despite being
+	// truncated.
+	return checkCapExceeded(w)
+}
+
+// NewExecutionTraceSource returns a TimedSource that collects a
+// runtime/trace execution trace for the requested duration.
+func NewExecutionTraceSource() TimedSource {
+	return &executionTraceSource{}
+}
+
+type executionTraceSource struct{}
+
+func (s *executionTraceSource) Write(ctx context.Context, w io.Writer, d time.Duration) error {
+	if err := trace.Start(w); err != nil {
+		return err
+	}
+	defer trace.Stop()
+	waitFor(ctx, d)
+	return nil
+}
+
+// NewBlockProfileSource returns a TimedSource that enables block profiling
+// for the requested duration and writes the resulting profile.
+func NewBlockProfileSource() TimedSource {
+	return &rateProfileSource{
+		profileName: "block",
+		enable:      runtime.SetBlockProfileRate,
+		disable:     0,
+	}
+}
+
+// NewMutexProfileSource returns a TimedSource that enables mutex profiling
+// for the requested duration and writes the resulting profile.
+func NewMutexProfileSource() TimedSource {
+	return &fractionProfileSource{
+		profileName: "mutex",
+		enable:      runtime.SetMutexProfileFraction,
+		disable:     0,
+	}
+}
+
+// NewThreadCreateProfileSource returns a TimedSource that waits for the
+// requested duration and then writes the threadcreate profile.
+func NewThreadCreateProfileSource() TimedSource {
+	return &lookupProfileSource{profileName: "threadcreate"}
+}
+
+// NewAllocsProfileSource returns a TimedSource that waits for the requested
+// duration and then writes the allocs profile.
+func NewAllocsProfileSource() TimedSource {
+	return &lookupProfileSource{profileName: "allocs"}
+}
+
+// rateProfileSource drives a profile that is toggled on via a sample rate,
+// e.g. the block profile.
+type rateProfileSource struct {
+	profileName string
+	enable      func(int)
+	disable     int
+}
+
+func (s *rateProfileSource) Write(ctx context.Context, w io.Writer, d time.Duration) error {
+	s.enable(1)
+	defer s.enable(s.disable)
+	waitFor(ctx, d)
+	return pprof.Lookup(s.profileName).WriteTo(w, 0)
+}
+
+// fractionProfileSource drives a profile that is toggled on via a sample
+// fraction, e.g. the mutex profile.
+type fractionProfileSource struct {
+	profileName string
+	enable      func(int) int
+	disable     int
+}
+
+func (s *fractionProfileSource) Write(ctx context.Context, w io.Writer, d time.Duration) error {
+	s.enable(1)
+	defer s.enable(s.disable)
+	waitFor(ctx, d)
+	return pprof.Lookup(s.profileName).WriteTo(w, 0)
+}
+
+// lookupProfileSource waits out the requested duration and then snapshots a
+// profile that m3 doesn't otherwise need to toggle on, e.g. threadcreate.
+type lookupProfileSource struct {
+	profileName string
+}
+
+func (s *lookupProfileSource) Write(ctx context.Context, w io.Writer, d time.Duration) error {
+	waitFor(ctx, d)
+	return pprof.Lookup(s.profileName).WriteTo(w, 0)
+}
+
+// waitFor blocks until either d has elapsed or ctx is done, whichever comes
+// first, so a cancelled ctx lets a TimedSource give up on the requested
+// duration early instead of holding process-global profiler state for the
+// remainder of d.
+func waitFor(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
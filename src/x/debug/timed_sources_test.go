@@ -0,0 +1,90 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package debug
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContinuousCPUProfileSource(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	err := NewContinuousCPUProfileSource().Write(context.Background(), buff, 5*time.Millisecond)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestExecutionTraceSource(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	err := NewExecutionTraceSource().Write(context.Background(), buff, 5*time.Millisecond)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestBlockProfileSource(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	err := NewBlockProfileSource().Write(context.Background(), buff, time.Millisecond)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestMutexProfileSource(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	err := NewMutexProfileSource().Write(context.Background(), buff, time.Millisecond)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestThreadCreateProfileSource(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	err := NewThreadCreateProfileSource().Write(context.Background(), buff, time.Millisecond)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestAllocsProfileSource(t *testing.T) {
+	buff := bytes.NewBuffer([]byte{})
+	err := NewAllocsProfileSource().Write(context.Background(), buff, time.Millisecond)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestContinuousCPUProfileSourceCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buff := bytes.NewBuffer([]byte{})
+	err := NewContinuousCPUProfileSource().Write(ctx, buff, time.Hour)
+	require.NoError(t, err)
+	require.NotZero(t, buff.Len())
+}
+
+func TestContinuousCPUProfileSourceCapExceeded(t *testing.T) {
+	// runtime/pprof's CPU profile writer swallows write errors internally,
+	// so without checkCapExceeded this would report success despite being
+	// truncated.
+	capped := &cappedWriter{w: &bytes.Buffer{}, limit: 1}
+	err := NewContinuousCPUProfileSource().Write(context.Background(), capped, 50*time.Millisecond)
+	require.ErrorIs(t, err, errSourceExceededByteCap)
+}